@@ -0,0 +1,47 @@
+// Package metrics defines a backend-agnostic metrics interface that RITA
+// subsystems can instrument against, plus Prometheus and statsd adapters.
+package metrics
+
+// Counter is a monotonically increasing value, e.g. a count of documents
+// written.
+type Counter interface {
+	Inc()
+	Add(v float64)
+}
+
+// Gauge is a value that can go up or down, e.g. a channel depth.
+type Gauge interface {
+	Set(v float64)
+	Inc()
+	Dec()
+	Add(v float64)
+}
+
+// Histogram samples observations into buckets, e.g. write latencies.
+type Histogram interface {
+	Observe(v float64)
+}
+
+// CounterVec, GaugeVec, and HistogramVec hand back the Counter/Gauge/
+// Histogram for one combination of label values, creating it on first use.
+type (
+	CounterVec interface {
+		WithLabelValues(labelValues ...string) Counter
+	}
+	GaugeVec interface {
+		WithLabelValues(labelValues ...string) Gauge
+	}
+	HistogramVec interface {
+		WithLabelValues(labelValues ...string) Histogram
+	}
+)
+
+// Registry creates the Counters, Gauges, and Histograms a RITA subsystem
+// reports through. NewNoopRegistry is the default when no exporter is
+// configured; PrometheusRegistry and StatsdRegistry back onto real
+// backends.
+type Registry interface {
+	Counter(name, help string, labelNames ...string) CounterVec
+	Gauge(name, help string, labelNames ...string) GaugeVec
+	Histogram(name, help string, buckets []float64, labelNames ...string) HistogramVec
+}
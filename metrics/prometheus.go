@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// PrometheusRegistry adapts a dedicated prometheus.Registry to the
+// Registry interface and can serve it over HTTP.
+type PrometheusRegistry struct {
+	reg *prometheus.Registry
+
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+// NewPrometheusRegistry builds an empty PrometheusRegistry.
+func NewPrometheusRegistry() *PrometheusRegistry {
+	return &PrometheusRegistry{
+		reg:        prometheus.NewRegistry(),
+		counters:   make(map[string]*prometheus.CounterVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+	}
+}
+
+// Counter returns the CounterVec registered under name, registering a new
+// one on first use. Calling this with the same name more than once -- e.g.
+// SetMetrics against a shared registry for more than one DocWriter, or a
+// MirrorSink with several MongoSink subscribers -- reuses the existing vec
+// instead of panicking on a duplicate registration.
+func (p *PrometheusRegistry) Counter(name, help string, labelNames ...string) CounterVec {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if vec, ok := p.counters[name]; ok {
+		return promCounterVec{vec}
+	}
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)
+	p.reg.MustRegister(vec)
+	p.counters[name] = vec
+	return promCounterVec{vec}
+}
+
+// Gauge returns the GaugeVec registered under name, registering a new one
+// on first use; see Counter for why repeat calls are de-duplicated.
+func (p *PrometheusRegistry) Gauge(name, help string, labelNames ...string) GaugeVec {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if vec, ok := p.gauges[name]; ok {
+		return promGaugeVec{vec}
+	}
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)
+	p.reg.MustRegister(vec)
+	p.gauges[name] = vec
+	return promGaugeVec{vec}
+}
+
+// Histogram returns the HistogramVec registered under name, registering a
+// new one on first use; see Counter for why repeat calls are de-duplicated.
+func (p *PrometheusRegistry) Histogram(name, help string, buckets []float64, labelNames ...string) HistogramVec {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if vec, ok := p.histograms[name]; ok {
+		return promHistogramVec{vec}
+	}
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    name,
+		Help:    help,
+		Buckets: buckets,
+	}, labelNames)
+	p.reg.MustRegister(vec)
+	p.histograms[name] = vec
+	return promHistogramVec{vec}
+}
+
+// Serve starts an HTTP server exposing the registry's metrics at /metrics.
+// It blocks until the server stops or errors, so RITA runs it in its own
+// goroutine when an operator opts in to metrics.
+func (p *PrometheusRegistry) Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(p.reg, promhttp.HandlerOpts{}))
+	return http.ListenAndServe(addr, mux)
+}
+
+type promCounterVec struct{ vec *prometheus.CounterVec }
+
+func (p promCounterVec) WithLabelValues(labelValues ...string) Counter {
+	return p.vec.WithLabelValues(labelValues...)
+}
+
+type promGaugeVec struct{ vec *prometheus.GaugeVec }
+
+func (p promGaugeVec) WithLabelValues(labelValues ...string) Gauge {
+	return p.vec.WithLabelValues(labelValues...)
+}
+
+type promHistogramVec struct{ vec *prometheus.HistogramVec }
+
+func (p promHistogramVec) WithLabelValues(labelValues ...string) Histogram {
+	return p.vec.WithLabelValues(labelValues...)
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import "testing"
+
+func TestPrometheusRegistryCounterDeduplicatesByName(t *testing.T) {
+	reg := NewPrometheusRegistry()
+
+	first := reg.Counter("rita_docwriter_mongo_inserts_total", "first", "db", "coll")
+	second := reg.Counter("rita_docwriter_mongo_inserts_total", "second", "db", "coll")
+
+	if first.(promCounterVec).vec != second.(promCounterVec).vec {
+		t.Error("expected a repeat Counter call with the same name to reuse the registered vec")
+	}
+}
+
+func TestPrometheusRegistryGaugeDeduplicatesByName(t *testing.T) {
+	reg := NewPrometheusRegistry()
+
+	first := reg.Gauge("rita_docwriter_databases", "first")
+	second := reg.Gauge("rita_docwriter_databases", "second")
+
+	if first.(promGaugeVec).vec != second.(promGaugeVec).vec {
+		t.Error("expected a repeat Gauge call with the same name to reuse the registered vec")
+	}
+}
+
+func TestPrometheusRegistryHistogramDeduplicatesByName(t *testing.T) {
+	reg := NewPrometheusRegistry()
+
+	first := reg.Histogram("rita_docwriter_mongo_flush_seconds", "first", nil, "db", "coll")
+	second := reg.Histogram("rita_docwriter_mongo_flush_seconds", "second", nil, "db", "coll")
+
+	if first.(promHistogramVec).vec != second.(promHistogramVec).vec {
+		t.Error("expected a repeat Histogram call with the same name to reuse the registered vec")
+	}
+}
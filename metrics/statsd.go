@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/cactus/go-statsd-client/statsd"
+)
+
+// StatsdRegistry adapts a statsd.Statter to the Registry interface.
+// statsd has no notion of labels, so WithLabelValues encodes them as a
+// dot-separated suffix on the stat name.
+type StatsdRegistry struct {
+	client statsd.Statter
+}
+
+// NewStatsdRegistry wraps an already-configured statsd client.
+func NewStatsdRegistry(client statsd.Statter) *StatsdRegistry {
+	return &StatsdRegistry{client: client}
+}
+
+func (s *StatsdRegistry) Counter(name, help string, labelNames ...string) CounterVec {
+	return statsdCounterVec{client: s.client, name: name}
+}
+
+func (s *StatsdRegistry) Gauge(name, help string, labelNames ...string) GaugeVec {
+	return statsdGaugeVec{client: s.client, name: name}
+}
+
+func (s *StatsdRegistry) Histogram(name, help string, buckets []float64, labelNames ...string) HistogramVec {
+	return statsdHistogramVec{client: s.client, name: name}
+}
+
+func statName(name string, labelValues []string) string {
+	if len(labelValues) == 0 {
+		return name
+	}
+	return name + "." + strings.Join(labelValues, ".")
+}
+
+type statsdCounterVec struct {
+	client statsd.Statter
+	name   string
+}
+
+func (v statsdCounterVec) WithLabelValues(labelValues ...string) Counter {
+	return statsdCounter{client: v.client, name: statName(v.name, labelValues)}
+}
+
+type statsdCounter struct {
+	client statsd.Statter
+	name   string
+}
+
+func (c statsdCounter) Inc()          { c.client.Inc(c.name, 1, 1.0) }
+func (c statsdCounter) Add(v float64) { c.client.Inc(c.name, int64(v), 1.0) }
+
+type statsdGaugeVec struct {
+	client statsd.Statter
+	name   string
+}
+
+func (v statsdGaugeVec) WithLabelValues(labelValues ...string) Gauge {
+	return statsdGauge{client: v.client, name: statName(v.name, labelValues)}
+}
+
+type statsdGauge struct {
+	client statsd.Statter
+	name   string
+}
+
+func (g statsdGauge) Set(v float64) { g.client.Gauge(g.name, int64(v), 1.0) }
+func (g statsdGauge) Inc()          { g.client.Inc(g.name, 1, 1.0) }
+func (g statsdGauge) Dec()          { g.client.Dec(g.name, 1, 1.0) }
+func (g statsdGauge) Add(v float64) { g.client.Inc(g.name, int64(v), 1.0) }
+
+type statsdHistogramVec struct {
+	client statsd.Statter
+	name   string
+}
+
+func (v statsdHistogramVec) WithLabelValues(labelValues ...string) Histogram {
+	return statsdHistogram{client: v.client, name: statName(v.name, labelValues)}
+}
+
+type statsdHistogram struct {
+	client statsd.Statter
+	name   string
+}
+
+// Observe reports v (seconds) as a statsd timing in milliseconds.
+func (h statsdHistogram) Observe(v float64) {
+	h.client.Timing(h.name, int64(v*1000), 1.0)
+}
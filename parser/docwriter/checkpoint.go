@@ -0,0 +1,113 @@
+package docwriter
+
+import (
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// checkpointsColl is the meta-DB collection DocWriter records progress in.
+const checkpointsColl = "rita_checkpoints"
+
+// checkpointRecord is the on-disk shape of one source's progress.
+type checkpointRecord struct {
+	Source  string `bson:"source"`
+	Offset  int64  `bson:"offset"`
+	Pending int64  `bson:"pending"`
+}
+
+// checkpointTracker holds, per input source, the highest offset Write has
+// seen (pending) and the highest offset the sink has reported durable
+// through its OnCommit callback (committed). DocWriter.Checkpoint snapshots
+// both.
+type checkpointTracker struct {
+	mu        sync.Mutex
+	pending   map[string]int64
+	committed map[string]int64
+}
+
+func newCheckpointTracker() *checkpointTracker {
+	return &checkpointTracker{
+		pending:   make(map[string]int64),
+		committed: make(map[string]int64),
+	}
+}
+
+func (c *checkpointTracker) markPending(source string, offset int64) {
+	if source == "" {
+		return
+	}
+	c.mu.Lock()
+	if offset > c.pending[source] {
+		c.pending[source] = offset
+	}
+	c.mu.Unlock()
+}
+
+func (c *checkpointTracker) markCommitted(source string, offset int64) {
+	if source == "" {
+		return
+	}
+	c.mu.Lock()
+	if offset > c.committed[source] {
+		c.committed[source] = offset
+	}
+	c.mu.Unlock()
+}
+
+func (c *checkpointTracker) snapshot() []checkpointRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records := make([]checkpointRecord, 0, len(c.committed))
+	for source, offset := range c.committed {
+		records = append(records, checkpointRecord{
+			Source:  source,
+			Offset:  offset,
+			Pending: c.pending[source],
+		})
+	}
+	return records
+}
+
+// Checkpoint snapshots the highest committed offset and the highest offset
+// Write has accepted (stored as "pending"; this is an offset, not a count of
+// writes still outstanding) for every source with a committed offset, and
+// persists it to the rita_checkpoints collection on the meta DB. It does not
+// stop or block the write loop.
+func (d *DocWriter) Checkpoint() error {
+	records := d.ckpt.snapshot()
+	if len(records) == 0 {
+		return nil
+	}
+
+	ssn := d.Ssn.Copy()
+	defer ssn.Close()
+	coll := d.Meta.DB.C(checkpointsColl).With(ssn)
+
+	for _, rec := range records {
+		_, err := coll.Upsert(
+			bson.M{"source": rec.Source},
+			bson.M{"$set": bson.M{"offset": rec.Offset, "pending": rec.Pending}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resume looks up the last committed checkpoint for source so an importer
+// can skip already-written lines after a crash or SIGINT. ok is false if no
+// checkpoint has ever been recorded for source.
+func (d *DocWriter) Resume(source string) (offset int64, ok bool) {
+	ssn := d.Ssn.Copy()
+	defer ssn.Close()
+	coll := d.Meta.DB.C(checkpointsColl).With(ssn)
+
+	var rec checkpointRecord
+	if err := coll.Find(bson.M{"source": source}).One(&rec); err != nil {
+		return 0, false
+	}
+	return rec.Offset, true
+}
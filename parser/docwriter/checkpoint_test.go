@@ -0,0 +1,65 @@
+package docwriter
+
+import "testing"
+
+func TestCheckpointTrackerMarkPendingKeepsHighWaterMark(t *testing.T) {
+	c := newCheckpointTracker()
+	c.markPending("bro.log", 10)
+	c.markPending("bro.log", 5)
+	c.markPending("bro.log", 20)
+
+	if got := c.pending["bro.log"]; got != 20 {
+		t.Errorf("expected pending offset to stay at the high-water mark 20, got %d", got)
+	}
+}
+
+func TestCheckpointTrackerMarkCommittedKeepsHighWaterMark(t *testing.T) {
+	c := newCheckpointTracker()
+	c.markCommitted("bro.log", 10)
+	c.markCommitted("bro.log", 5)
+	c.markCommitted("bro.log", 20)
+
+	if got := c.committed["bro.log"]; got != 20 {
+		t.Errorf("expected committed offset to stay at the high-water mark 20, got %d", got)
+	}
+}
+
+func TestCheckpointTrackerMarkIgnoresEmptySource(t *testing.T) {
+	c := newCheckpointTracker()
+	c.markPending("", 10)
+	c.markCommitted("", 10)
+
+	if len(c.pending) != 0 || len(c.committed) != 0 {
+		t.Error("expected an empty source to be ignored entirely")
+	}
+}
+
+func TestCheckpointTrackerSnapshotPairsPendingAndCommitted(t *testing.T) {
+	c := newCheckpointTracker()
+	c.markPending("a.log", 100)
+	c.markCommitted("a.log", 40)
+	c.markPending("b.log", 7)
+	// b.log has no committed offset yet -- it must not appear in the snapshot.
+
+	records := c.snapshot()
+	if len(records) != 1 {
+		t.Fatalf("expected only sources with a committed offset in the snapshot, got %d records", len(records))
+	}
+
+	rec := records[0]
+	if rec.Source != "a.log" || rec.Offset != 40 || rec.Pending != 100 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
+
+func TestCheckpointTrackerSnapshotIsASnapshot(t *testing.T) {
+	c := newCheckpointTracker()
+	c.markCommitted("a.log", 1)
+
+	records := c.snapshot()
+	c.markCommitted("a.log", 2)
+
+	if records[0].Offset != 1 {
+		t.Error("expected snapshot to hold the offset as of the time it was taken, unaffected by later updates")
+	}
+}
@@ -1,58 +1,183 @@
 package docwriter
 
 import (
-	"strings"
+	"context"
+	"errors"
+	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/bglebrun/rita/config"
 	"github.com/bglebrun/rita/database"
+	"github.com/bglebrun/rita/metrics"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/davecgh/go-spew/spew"
 	"gopkg.in/mgo.v2"
 )
 
+const (
+	// defaultBatchSize is used when config.SystemConfig.BatchSize is unset
+	defaultBatchSize = 500
+	// defaultFlushInterval is used when config.SystemConfig.FlushInterval is unset
+	defaultFlushInterval = 5 * time.Second
+	// defaultCheckpointInterval is used when config.SystemConfig.CheckpointInterval is unset
+	defaultCheckpointInterval = 30 * time.Second
+	// defaultShutdownDeadline bounds how long HandleSignals waits for a
+	// drain-and-flush to finish before giving up
+	defaultShutdownDeadline = 30 * time.Second
+)
+
+// ErrShuttingDown is returned by Write once HandleSignals has begun
+// shutting the DocWriter down
+var ErrShuttingDown = errors.New("docwriter: shutting down, not accepting new writes")
+
 type (
 	// Document holds one item to be written to a database
 	Document struct {
 		Doc  interface{} // Thing to write
 		DB   string      // DB to write to
 		Coll string      // Collection to write to
+
+		// Fields below are used for Whitelist matching only; they are
+		// never written to the database.
+		SrcIP string // Source IP of the record this document came from
+		DstIP string // Destination IP of the record this document came from
+		Host  string // Host header / hostname associated with the record
+		URL   string // URL associated with the record
+
+		// Source and Offset are used for checkpointing only; they are
+		// never written to the database. Source identifies the input (e.g.
+		// a Bro log file path) and Offset is how far into it this document
+		// came from (e.g. a byte offset or line number).
+		Source string
+		Offset int64
 	}
 
 	// DocWriter writes documents to a database
 	DocWriter struct {
-		Ssn       *mgo.Session           // Session to db instance
-		pre       string                 // Prefix to the database
-		ImportWl  bool                   // Flag to import whitelist
-		Whitelist []string               // Pointer to our whitelist array
-		wchan     chan Document          // Document channel
-		log       *log.Logger            // Logging
-		wg        *sync.WaitGroup        // Used to block until complete
-		Meta      *database.MetaDBHandle // Handle to metadata
-		Databases []string               // Track the db states
-		started   bool                   // Track if we've started the writer
-		dblock    *sync.Mutex            // For the Databases fields
+		Ssn                *mgo.Session           // Session to db instance
+		pre                string                 // Prefix to the database
+		ImportWl           bool                   // Flag to import whitelist
+		Whitelist          *Whitelist             // Compiled whitelist rules
+		sink               Sink                   // Where documents end up; defaults to a MongoSink
+		wchan              chan Document          // Document channel
+		log                *log.Logger            // Logging
+		wg                 *sync.WaitGroup        // Used to block until complete
+		Meta               *database.MetaDBHandle // Handle to metadata
+		Databases          []string               // Track the db states
+		started            bool                   // Track if we've started the writer
+		dblock             *sync.Mutex            // For the Databases fields
+		ckpt               *checkpointTracker     // Per-source write progress
+		checkpointInterval time.Duration          // How often Checkpoint runs in the background
+		stopCkpt           chan struct{}          // Closed by Flush to stop the checkpoint loop
+		shuttingDown       int32                  // Set by HandleSignals once shutdown has begun
+		ShutdownDeadline   time.Duration          // How long HandleSignals waits for a drain to finish
+		flushOnce          sync.Once              // Makes Flush safe to call more than once
+		dwMetrics          *docWriterMetrics      // Observability; defaults to no-ops
 	}
 )
 
-// New generates a new DocWriter
+// docWriterMetrics bundles the Counters, Gauges, and Histograms DocWriter
+// itself reports (MongoSink has its own, for insert/retry counts).
+type docWriterMetrics struct {
+	writeChanDepth metrics.Gauge
+	databases      metrics.Gauge
+}
+
+func newDocWriterMetrics(reg metrics.Registry) *docWriterMetrics {
+	return &docWriterMetrics{
+		writeChanDepth: reg.Gauge("rita_docwriter_write_chan_depth", "Documents currently buffered in DocWriter.wchan").WithLabelValues(),
+		databases:      reg.Gauge("rita_docwriter_databases", "Number of databases DocWriter has written to").WithLabelValues(),
+	}
+}
+
+// New generates a new DocWriter, writing to MongoDB through a MongoSink
 func New(cfg *config.Resources, mdb *database.MetaDBHandle) *DocWriter {
 
 	dbs := mdb.GetDatabases()
-	return &DocWriter{
-		Ssn:       cfg.Session.Copy(),
-		log:       cfg.Log,
-		pre:       cfg.System.BroConfig.DBPrefix,
-		ImportWl:  cfg.System.ImportWhitelist,
-		Whitelist: cfg.System.Whitelist,
-		wchan:     make(chan Document, 5000),
-		wg:        new(sync.WaitGroup),
-		Meta:      mdb,
-		Databases: dbs,
-		started:   false,
-		dblock:    new(sync.Mutex)}
+
+	batchSize := cfg.System.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	flushInterval := cfg.System.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+
+	checkpointInterval := cfg.System.CheckpointInterval
+	if checkpointInterval <= 0 {
+		checkpointInterval = defaultCheckpointInterval
+	}
+
+	ssn := cfg.Session.Copy()
+
+	whitelist, err := NewWhitelist(cfg.System.Whitelist)
+	if err != nil {
+		cfg.Log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Some whitelist rules failed to parse and were ignored")
+	}
+
+	d := &DocWriter{
+		Ssn:                ssn,
+		log:                cfg.Log,
+		pre:                cfg.System.BroConfig.DBPrefix,
+		ImportWl:           cfg.System.ImportWhitelist,
+		Whitelist:          whitelist,
+		sink:               NewMongoSink(ssn, cfg.Log, batchSize, flushInterval),
+		wchan:              make(chan Document, 5000),
+		wg:                 new(sync.WaitGroup),
+		Meta:               mdb,
+		Databases:          dbs,
+		started:            false,
+		dblock:             new(sync.Mutex),
+		ckpt:               newCheckpointTracker(),
+		checkpointInterval: checkpointInterval,
+		stopCkpt:           make(chan struct{}),
+		ShutdownDeadline:   defaultShutdownDeadline,
+		dwMetrics:          newDocWriterMetrics(metrics.NewNoopRegistry())}
+	d.wireOnCommit()
+	return d
+}
+
+// SetSink overrides the default MongoSink, e.g. with a MirrorSink fanning
+// out to several backends. It must be called before Start.
+func (d *DocWriter) SetSink(s Sink) {
+	d.sink = s
+	d.wireOnCommit()
+}
+
+// wireOnCommit points the sink's commit callback, if it supports one, at
+// d.ckpt.markCommitted. A Sink only becomes "committed" once it reports a
+// document durable this way; Sinks that don't support OnCommit (e.g. a bare
+// MirrorSink) never advance the checkpoint for documents routed only to
+// them, which is conservative -- a Resume may redo more work than strictly
+// necessary, but it will never skip a document that was actually lost.
+func (d *DocWriter) wireOnCommit() {
+	if cs, ok := d.sink.(interface {
+		OnCommit(func(Document))
+	}); ok {
+		cs.OnCommit(func(doc Document) {
+			d.ckpt.markCommitted(doc.Source, doc.Offset)
+		})
+	}
+}
+
+// SetMetrics points DocWriter's gauges -- and the current Sink's counters,
+// gauges, and histograms, if it supports metrics -- at reg. Call it before Start;
+// it defaults to a no-op Registry otherwise.
+func (d *DocWriter) SetMetrics(reg metrics.Registry) {
+	d.dwMetrics = newDocWriterMetrics(reg)
+	if ms, ok := d.sink.(interface {
+		SetMetrics(metrics.Registry)
+	}); ok {
+		ms.SetMetrics(reg)
+	}
 }
 
 // Start begins the DocWriter spinning on its input
@@ -63,17 +188,45 @@ func (d *DocWriter) Start(count int) {
 			d.started = true
 			go d.writeLoop()
 		}
+		d.wg.Add(1)
+		go d.checkpointLoop()
 	}
 	return
 }
 
+// checkpointLoop periodically persists write progress so a crashed or
+// interrupted import can Resume without stopping the write loop.
+func (d *DocWriter) checkpointLoop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Checkpoint(); err != nil {
+				d.log.WithFields(log.Fields{
+					"error": err.Error(),
+				}).Error("Checkpoint failed")
+			}
+		case <-d.stopCkpt:
+			return
+		}
+	}
+}
+
 // IsStarted checks to see if the writer is already going
 func (d *DocWriter) IsStarted() bool {
 	return d.started
 }
 
-// Write allows a user to add to the channel
-func (d *DocWriter) Write(doc Document) {
+// Write allows a user to add to the channel. It returns ErrShuttingDown
+// once HandleSignals has begun draining the DocWriter.
+func (d *DocWriter) Write(doc Document) error {
+	if atomic.LoadInt32(&d.shuttingDown) != 0 {
+		return ErrShuttingDown
+	}
+
 	doc.DB = d.pre + doc.DB
 	seen := false
 	d.dblock.Lock()
@@ -87,102 +240,118 @@ func (d *DocWriter) Write(doc Document) {
 		d.Meta.AddNewDB(doc.DB)
 		d.Databases = append(d.Databases, doc.DB)
 	}
+	dbCount := len(d.Databases)
 	d.dblock.Unlock()
+	d.dwMetrics.databases.Set(float64(dbCount))
+	d.ckpt.markPending(doc.Source, doc.Offset)
 	d.wchan <- doc
-	return
+	return nil
 }
 
-// Flush writes the final documents to the db and exits docwriter
+// Flush writes the final documents to the db and exits docwriter. It is
+// safe to call more than once (HandleSignals may call it alongside a
+// caller's own shutdown path).
 func (d *DocWriter) Flush() {
-	d.log.Debug("closing write channel")
-	close(d.wchan)
-	d.log.Debug("waiting for writes to finish")
-	d.wg.Wait()
-	d.log.Debug("writes completed, exiting write loop")
-	return
+	d.flushOnce.Do(func() {
+		d.log.Debug("closing write channel")
+		close(d.wchan)
+		close(d.stopCkpt)
+		d.log.Debug("waiting for writes to finish")
+		d.wg.Wait()
+		if err := d.Checkpoint(); err != nil {
+			d.log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("Final checkpoint failed")
+		}
+		// d.Ssn backs Checkpoint/Resume as well as the default MongoSink (and,
+		// via SetSink, any MongoSink subscribers wired into a MirrorSink), so
+		// it must stay open until everything above is done with it -- Sink.Close
+		// only closes the copies a sink makes via Session.Copy(), never the
+		// root session.
+		d.Ssn.Close()
+		d.log.Debug("writes completed, exiting write loop")
+	})
 }
 
-/*
- * Ben L.
- * Checks if our document is present in the whitelist
- * and returns true if the string is whitelisted, false
- * otherwise
- */
-func isWhitelisted(whitelist []string, url string) bool {
-	if whitelist == nil {
-		return false
-	}
-	for count := range whitelist {
-		if strings.Contains(url, whitelist[count]) {
-			return true
-		}
+// HandleSignals installs handlers for SIGINT, SIGTERM, and SIGQUIT. On
+// receiving one, it stops the DocWriter from accepting new writes, drains
+// wchan, waits for in-flight sink writes and retries to finish, and persists
+// a final checkpoint. All of that is bounded by ShutdownDeadline: once the
+// deadline passes it returns without waiting further, and the drain and
+// final checkpoint carry on in the background. It returns early, without
+// touching the DocWriter, if ctx is cancelled first.
+func (d *DocWriter) HandleSignals(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sigCh)
+
+	select {
+	case sig := <-sigCh:
+		d.log.WithFields(log.Fields{
+			"signal": sig.String(),
+		}).Info("Received shutdown signal, draining DocWriter")
+	case <-ctx.Done():
+		return
 	}
-	return false
+
+	d.shutdown()
 }
 
-// writeLoop loops over the input channel spawning threads to write
-// TODO: implement whitelist code here, pass config somehow
+// shutdown stops the DocWriter from accepting new writes, then waits for
+// Flush to drain wchan and persist a final checkpoint, bounded by
+// ShutdownDeadline. Split out of HandleSignals so the post-signal behavior
+// can be driven directly in tests without sending a real OS signal.
+func (d *DocWriter) shutdown() {
+	atomic.StoreInt32(&d.shuttingDown, 1)
+
+	drained := make(chan struct{})
+	go func() {
+		d.Flush()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		d.log.Info("DocWriter drained cleanly, shutting down")
+	case <-time.After(d.ShutdownDeadline):
+		d.log.Error("DocWriter shutdown deadline exceeded, exiting with writes possibly still in flight")
+	}
+}
+
+// writeLoop loops over the input channel, handing each document to the
+// configured Sink. Buffering, batching, and backend-specific retry logic
+// all live in the Sink implementation, not here.
 func (d *DocWriter) writeLoop() {
-	var err error
 	d.wg.Add(1)
 	for {
-		d.log.WithFields(log.Fields{
-			"type":             "wldebug",
-			"write_chan_count": len(d.wchan),
-		}).Debug("WriteLoop status")
 		doc, ok := <-d.wchan
 		if !ok {
 			d.log.Info("WriteLoop got closed channel, exiting")
-			break
+			if err := d.sink.Close(); err != nil {
+				d.log.WithFields(log.Fields{
+					"error": err.Error(),
+				}).Error("Sink close failure")
+			}
+			d.wg.Done()
+			return
 		}
+
+		d.log.WithFields(log.Fields{
+			"type":             "wldebug",
+			"write_chan_count": len(d.wchan),
+		}).Debug("WriteLoop status")
+		d.dwMetrics.writeChanDepth.Set(float64(len(d.wchan)))
+
 		// Right here is where we check for our "import whitelist"
 		// option before proceeding for anything
-		ssn := d.Ssn.Copy()
-		// This is where we check for our whitelist!!!!
-		towrite := doc.Doc
-		// Find a way to grab our host name, original implementation sucked
-		if isWhitelisted(d.Whitelist, "This is a cool wholesome string") {
-			if d.ImportWl {
-				err = ssn.DB(doc.DB).C(doc.Coll).Insert(towrite)
-			}
-		} else {
-			err = ssn.DB(doc.DB).C(doc.Coll).Insert(towrite)
+		if d.Whitelist.Match(doc) && !d.ImportWl {
+			continue
 		}
-		if err != nil {
-			if strings.Contains(err.Error(), "ObjectIDs") {
-				spew.Dump(towrite)
-			}
+
+		if err := d.sink.Write(doc); err != nil {
 			d.log.WithFields(log.Fields{
 				"error": err.Error(),
-			}).Error("Database write failure")
-
-			d.expFalloff(&doc)
+			}).Error("Sink write failure")
 		}
-		ssn.Close()
-	}
-
-	d.Ssn.Close()
-	d.wg.Done()
-	return
-}
-
-// expFalloff is entered after dbwrite failure
-func (d *DocWriter) expFalloff(doc *Document) {
-	for i := 0; i < 5; i++ {
-		time.Sleep(time.Duration(i*i) * time.Second)
-		ssn := d.Ssn.Copy()
-		towrite := doc.Doc
-		err := ssn.DB(doc.DB).C(doc.Coll).Insert(towrite)
-		if err == nil {
-			ssn.Close()
-			d.log.Info("Write succeeded")
-			return
-		}
-		ssn.Close()
-		d.log.WithFields(log.Fields{
-			"error":   err.Error(),
-			"falloff": i,
-		}).Error("Database write failure")
-
 	}
 }
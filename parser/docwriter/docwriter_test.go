@@ -0,0 +1,86 @@
+package docwriter
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bglebrun/rita/database"
+	"github.com/bglebrun/rita/metrics"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+)
+
+// blockingSink is a Sink whose Write never returns, for driving the
+// shutdown-deadline-exceeded path without a real stalled backend.
+type blockingSink struct{}
+
+func (blockingSink) Write(Document) error { select {} }
+func (blockingSink) Flush() error         { return nil }
+func (blockingSink) Close() error         { return nil }
+
+// newTestDocWriter builds a DocWriter without going through New, so it
+// needs neither a live Mongo connection nor a real config.Resources --
+// Checkpoint short-circuits to nil with no tracked sources, and Ssn is
+// only ever Closed (a no-op on a freshly allocated, never-Copy'd Session),
+// never Copy'd.
+func newTestDocWriter(sink Sink) *DocWriter {
+	d := &DocWriter{
+		Ssn:                &mgo.Session{},
+		log:                log.New(),
+		sink:               sink,
+		wchan:              make(chan Document, 10),
+		wg:                 new(sync.WaitGroup),
+		Meta:               &database.MetaDBHandle{},
+		Databases:          nil,
+		dblock:             new(sync.Mutex),
+		ckpt:               newCheckpointTracker(),
+		checkpointInterval: defaultCheckpointInterval,
+		stopCkpt:           make(chan struct{}),
+		ShutdownDeadline:   defaultShutdownDeadline,
+		dwMetrics:          newDocWriterMetrics(metrics.NewNoopRegistry()),
+	}
+	d.wireOnCommit()
+	return d
+}
+
+func TestShutdownRejectsWritesAndFlushesOnce(t *testing.T) {
+	d := newTestDocWriter(&fakeSink{})
+	d.Start(1)
+
+	if err := d.Write(Document{DB: "d", Coll: "c"}); err != nil {
+		t.Fatalf("unexpected error before shutdown: %v", err)
+	}
+
+	d.shutdown()
+
+	if err := d.Write(Document{DB: "d", Coll: "c"}); err != ErrShuttingDown {
+		t.Errorf("expected ErrShuttingDown after shutdown, got %v", err)
+	}
+
+	// Flush already ran once inside shutdown; if it weren't idempotent this
+	// would double-close wchan/stopCkpt and panic.
+	d.Flush()
+}
+
+func TestShutdownRespectsDeadlineWhenFlushHangs(t *testing.T) {
+	d := newTestDocWriter(blockingSink{})
+	d.ShutdownDeadline = 20 * time.Millisecond
+	d.Start(1)
+
+	// Seed a document so writeLoop is blocked inside sink.Write when
+	// shutdown tries to drain it.
+	if err := d.Write(Document{DB: "d", Coll: "c"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for len(d.wchan) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	d.shutdown()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("shutdown should have returned at ShutdownDeadline, took %v", elapsed)
+	}
+}
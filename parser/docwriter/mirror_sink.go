@@ -0,0 +1,206 @@
+package docwriter
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// DeadSink is sent on a MirrorSink's dead-sink channel when a subscriber is
+// evicted for stalling.
+type DeadSink struct {
+	Name string
+	Err  error
+}
+
+// mirrorSubscriber is one fan-out leg of a MirrorSink: a Sink plus the
+// buffered channel and byte budget that gate how far it can fall behind.
+type mirrorSubscriber struct {
+	name           string
+	sink           Sink
+	ch             chan Document
+	maxBufferBytes int64
+	bufBytes       int64
+}
+
+// MirrorSink fans every Document out to a dynamic set of subscriber Sinks. A
+// central goroutine (MirrorSink.Write) never blocks on a slow subscriber: it
+// dispatches into each subscriber's buffered channel and evicts any
+// subscriber whose buffer is full or would exceed MaxBufferBytes, so one
+// stalled backend (a down replica, a full disk) can't stall the rest.
+type MirrorSink struct {
+	log  *log.Logger
+	mu   sync.RWMutex // protects subs
+	subs map[string]*mirrorSubscriber
+	dead chan DeadSink
+
+	closeOnce sync.Once
+	// closeMu is held for read for the duration of every Write call and
+	// for write by Close, so Close can't close a subscriber's channel out
+	// from under a send that's still in flight in another goroutine. It
+	// is deliberately separate from mu, which only ever needs to be held
+	// for the instant it takes to read or mutate the subs map.
+	closeMu sync.RWMutex
+}
+
+// NewMirrorSink builds an empty MirrorSink. Subscribers are added with Add.
+func NewMirrorSink(logger *log.Logger) *MirrorSink {
+	return &MirrorSink{
+		log:  logger,
+		subs: make(map[string]*mirrorSubscriber),
+		dead: make(chan DeadSink, 16),
+	}
+}
+
+// DeadSinks returns the channel MirrorSink reports evicted subscribers on.
+func (m *MirrorSink) DeadSinks() <-chan DeadSink {
+	return m.dead
+}
+
+// Add registers sink as a new subscriber under name, with bufSize pending
+// Documents and maxBufferBytes of approximate buffered size before it is
+// evicted. A maxBufferBytes of 0 disables the byte-based eviction check.
+func (m *MirrorSink) Add(name string, sink Sink, bufSize int, maxBufferBytes int64) {
+	sub := &mirrorSubscriber{
+		name:           name,
+		sink:           sink,
+		ch:             make(chan Document, bufSize),
+		maxBufferBytes: maxBufferBytes,
+	}
+	m.mu.Lock()
+	m.subs[name] = sub
+	m.mu.Unlock()
+
+	go m.drain(sub)
+}
+
+// drain is the subscriber's private goroutine: it writes everything that
+// lands in sub.ch to sub.sink, and evicts the subscriber on the first error.
+func (m *MirrorSink) drain(sub *mirrorSubscriber) {
+	for doc := range sub.ch {
+		atomic.AddInt64(&sub.bufBytes, -docSize(doc))
+		if err := sub.sink.Write(doc); err != nil {
+			m.evict(sub, err)
+			return
+		}
+	}
+}
+
+// evict removes sub from the live subscriber set, closes it, and reports it
+// on the dead-sink channel. It is a no-op if sub was already evicted.
+func (m *MirrorSink) evict(sub *mirrorSubscriber, err error) {
+	m.mu.Lock()
+	if m.subs[sub.name] != sub {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.subs, sub.name)
+	m.mu.Unlock()
+
+	// Unblocks drain's `for doc := range sub.ch` so its goroutine exits
+	// instead of leaking for the life of the process. Safe to close here:
+	// Write only sends to subscribers it finds in m.subs while holding mu,
+	// and sub was removed from m.subs under mu above, so no send to this
+	// channel can start or still be in flight when we close it.
+	close(sub.ch)
+	sub.sink.Close()
+
+	select {
+	case m.dead <- DeadSink{Name: sub.name, Err: err}:
+	default:
+		m.log.WithFields(log.Fields{
+			"sink":  sub.name,
+			"error": err.Error(),
+		}).Error("dead-sink channel full, dropping notification")
+	}
+}
+
+// Write fans doc out to every live subscriber without blocking on any of
+// them: a subscriber whose channel is full, or whose buffer would cross
+// MaxBufferBytes, is evicted instead of slowing down the rest.
+func (m *MirrorSink) Write(doc Document) error {
+	m.closeMu.RLock()
+	defer m.closeMu.RUnlock()
+
+	size := docSize(doc)
+
+	// Sends happen under mu so evict, which removes a subscriber under mu
+	// before closing its channel, can't close a channel mid-send. Evictions
+	// are applied once mu is released, since evict takes mu itself.
+	var evictSubs []*mirrorSubscriber
+	var evictErrs []error
+	m.mu.RLock()
+	for _, sub := range m.subs {
+		if sub.maxBufferBytes > 0 && atomic.LoadInt64(&sub.bufBytes)+size > sub.maxBufferBytes {
+			evictSubs = append(evictSubs, sub)
+			evictErrs = append(evictErrs, errors.New("subscriber exceeded MaxBufferBytes"))
+			continue
+		}
+		select {
+		case sub.ch <- doc:
+			atomic.AddInt64(&sub.bufBytes, size)
+		default:
+			evictSubs = append(evictSubs, sub)
+			evictErrs = append(evictErrs, errors.New("subscriber buffer full"))
+		}
+	}
+	m.mu.RUnlock()
+
+	for i, sub := range evictSubs {
+		m.evict(sub, evictErrs[i])
+	}
+	return nil
+}
+
+// Flush flushes every live subscriber, returning the first error seen.
+func (m *MirrorSink) Flush() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var firstErr error
+	for _, sub := range m.subs {
+		if err := sub.sink.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close closes every live subscriber and the dead-sink channel. It is safe
+// to call more than once -- e.g. from several DocWriter.Start(count)
+// workers that each close the shared sink once their input channel drains.
+func (m *MirrorSink) Close() error {
+	var firstErr error
+	m.closeOnce.Do(func() {
+		// Wait out every in-flight Write (and block new ones from
+		// starting) before closing subscriber channels, or a goroutine
+		// still inside Write's send could panic on a closed channel.
+		m.closeMu.Lock()
+		defer m.closeMu.Unlock()
+
+		m.mu.Lock()
+		subs := m.subs
+		m.subs = make(map[string]*mirrorSubscriber)
+		m.mu.Unlock()
+
+		for _, sub := range subs {
+			close(sub.ch)
+			if err := sub.sink.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		close(m.dead)
+	})
+	return firstErr
+}
+
+// docSize approximates a Document's buffered size for MaxBufferBytes
+// accounting. It is deliberately approximate -- the real encoded size
+// varies per sink -- but keeps eviction decisions proportional to payload
+// size rather than document count alone.
+func docSize(doc Document) int64 {
+	return int64(len(spew.Sdump(doc.Doc)))
+}
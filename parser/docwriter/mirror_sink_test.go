@@ -0,0 +1,197 @@
+package docwriter
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// fakeSink is a Sink that never touches Mongo, for exercising MirrorSink's
+// fan-out and eviction logic in isolation.
+type fakeSink struct {
+	mu      sync.Mutex
+	written []Document
+	closed  bool
+}
+
+func (f *fakeSink) Write(d Document) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.written = append(f.written, d)
+	return nil
+}
+func (f *fakeSink) Flush() error { return nil }
+func (f *fakeSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeSink) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.written)
+}
+
+func TestMirrorSinkWriteFansOutToEverySubscriber(t *testing.T) {
+	m := NewMirrorSink(log.New())
+	a, b := &fakeSink{}, &fakeSink{}
+	m.Add("a", a, 4, 0)
+	m.Add("b", b, 4, 0)
+
+	if err := m.Write(Document{DB: "d", Coll: "c"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for a.count() != 1 || b.count() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected both subscribers to receive the doc, got a=%d b=%d", a.count(), b.count())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMirrorSinkEvictsFullSubscriberWithoutBlocking(t *testing.T) {
+	m := NewMirrorSink(log.New())
+	slow := blockingSink{}
+	m.Add("slow", slow, 1, 0) // buffer of 1; drain stalls inside the first Write
+
+	m.mu.RLock()
+	sub := m.subs["slow"]
+	m.mu.RUnlock()
+	sub.ch <- Document{DB: "d", Coll: "c"} // fill the buffer so drain can't keep up
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := m.Write(Document{DB: "d", Coll: "c"}); err != nil {
+			t.Fatalf("MirrorSink.Write should never return an error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("MirrorSink.Write blocked on a stalled subscriber: took %v", elapsed)
+	}
+
+	select {
+	case dead := <-m.DeadSinks():
+		if dead.Name != "slow" {
+			t.Errorf("expected slow sink to be evicted, got %q", dead.Name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a dead-sink notification after exceeding the buffer")
+	}
+}
+
+func TestMirrorSinkEvictOnWriteErrorClosesSink(t *testing.T) {
+	m := NewMirrorSink(log.New())
+	failing := &failingSink{err: errors.New("disk full")}
+	m.Add("failing", failing, 4, 0)
+
+	if err := m.Write(Document{DB: "d", Coll: "c"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case dead := <-m.DeadSinks():
+		if dead.Name != "failing" {
+			t.Errorf("expected failing sink to be evicted, got %q", dead.Name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a dead-sink notification after a write error")
+	}
+	if !failing.wasClosed() {
+		t.Error("expected the evicted sink to be closed")
+	}
+}
+
+// failingSink always errors on Write, to trigger MirrorSink's drain-side
+// eviction path.
+type failingSink struct {
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+func (f *failingSink) Write(Document) error { return f.err }
+func (f *failingSink) Flush() error         { return nil }
+func (f *failingSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+func (f *failingSink) wasClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func TestMirrorSinkCloseTwiceIsANoOp(t *testing.T) {
+	m := NewMirrorSink(log.New())
+	m.Add("a", &fakeSink{}, 4, 0)
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("second close should be a no-op, got: %v", err)
+	}
+}
+
+// TestMirrorSinkCloseDoesNotRaceInFlightWrites mirrors how
+// DocWriter.Start(count) uses a MirrorSink: several goroutines all call
+// Write off a shared wchan and any of them may call Close the instant it
+// sees wchan closed, while a sibling goroutine is still inside Write for
+// the last document it received. Close must not close a subscriber's
+// channel while a send to it is in flight.
+func TestMirrorSinkCloseDoesNotRaceInFlightWrites(t *testing.T) {
+	m := NewMirrorSink(log.New())
+	m.Add("a", &fakeSink{}, 64, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				m.Write(Document{DB: "d", Coll: "c"})
+			}
+		}()
+	}
+
+	m.Close()
+	wg.Wait()
+}
+
+// TestMirrorSinkEvictDoesNotLeakDrainGoroutine guards against evict
+// removing a subscriber from the live set without also unblocking its
+// drain goroutine -- for a sink meant to keep running through flapping
+// backends over multi-hour imports, that's a goroutine leaked per eviction
+// for the life of the process.
+func TestMirrorSinkEvictDoesNotLeakDrainGoroutine(t *testing.T) {
+	m := NewMirrorSink(log.New())
+	before := runtime.NumGoroutine()
+
+	m.Add("flapping", &failingSink{err: errors.New("disk full")}, 4, 0)
+	if err := m.Write(Document{DB: "d", Coll: "c"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-m.DeadSinks() // wait for the eviction to complete
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("drain goroutine leaked after eviction: have %d, started with %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
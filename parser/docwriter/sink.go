@@ -0,0 +1,275 @@
+package docwriter
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bglebrun/rita/metrics"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/davecgh/go-spew/spew"
+	"gopkg.in/mgo.v2"
+)
+
+// Sink is a destination documents can be written to. DocWriter's write loop
+// is Sink-agnostic: it only knows how to pull Documents off wchan and hand
+// them to whatever Sink it was built with, so additional backends (a JSON
+// file, stdout, a secondary Mongo replica...) are added by writing a new
+// Sink rather than touching writeLoop.
+type Sink interface {
+	// Write hands a single Document to the sink. Implementations may buffer
+	// internally and flush later.
+	Write(Document) error
+	// Flush forces any buffered documents out to the backing store.
+	Flush() error
+	// Close flushes and releases any resources held by the sink. A closed
+	// sink is not written to again.
+	Close() error
+}
+
+// collKey identifies the (DB, Collection) pair a buffer belongs to
+type collKey struct {
+	DB   string
+	Coll string
+}
+
+// MongoSink is the default Sink. It buffers documents per (DB, Collection)
+// pair and flushes them as a single Bulk().Insert() once BatchSize is
+// reached or FlushInterval elapses, falling back to expFalloff's retry
+// logic for documents a bulk insert rejects.
+type MongoSink struct {
+	base          *mgo.Session
+	log           *log.Logger
+	batchSize     int
+	flushInterval time.Duration
+
+	mu      sync.Mutex
+	buffers map[collKey][]Document
+
+	ticker    *time.Ticker
+	done      chan struct{}
+	closeOnce sync.Once
+
+	onCommit func(Document) // reported once a doc is durably bulk-inserted; nil until OnCommit is called
+
+	insertsTotal metrics.CounterVec // labels: db, coll
+	retriesTotal metrics.Counter
+	flushLatency metrics.HistogramVec // labels: db, coll -- time spent in bulk.Run()
+}
+
+// NewMongoSink builds a MongoSink. base is copied for every bulk write and
+// retry, and is never written to directly. Metrics are a no-op until
+// SetMetrics is called.
+func NewMongoSink(base *mgo.Session, logger *log.Logger, batchSize int, flushInterval time.Duration) *MongoSink {
+	m := &MongoSink{
+		base:          base,
+		log:           logger,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		buffers:       make(map[collKey][]Document),
+		ticker:        time.NewTicker(flushInterval),
+		done:          make(chan struct{}),
+	}
+	m.SetMetrics(metrics.NewNoopRegistry())
+	go m.tickLoop()
+	return m
+}
+
+// SetMetrics points the sink's insert, retry, and flush-latency metrics at
+// reg. Call it before Start to observe insert throughput, retry counts, and
+// actual bulk-insert latency.
+func (m *MongoSink) SetMetrics(reg metrics.Registry) {
+	m.insertsTotal = reg.Counter("rita_docwriter_mongo_inserts_total", "Documents successfully bulk-inserted by MongoSink", "db", "coll")
+	m.retriesTotal = reg.Counter("rita_docwriter_mongo_retries_total", "Per-document retries inside expFalloff after a bulk insert rejection").WithLabelValues()
+	m.flushLatency = reg.Histogram("rita_docwriter_mongo_flush_seconds", "Time spent in bulk.Run() during flushBuffer", nil, "db", "coll")
+}
+
+// OnCommit registers cb to be called once per Document, exactly when that
+// document has been durably written to Mongo -- i.e. from flushBuffer on a
+// successful bulk insert, or from expFalloff on a successful retry. It must
+// be called before Start; cb may be called concurrently from multiple
+// flushes and must not block.
+func (m *MongoSink) OnCommit(cb func(Document)) {
+	m.onCommit = cb
+}
+
+// tickLoop flushes on FlushInterval so a slow trickle of documents doesn't
+// sit buffered forever waiting for BatchSize to be hit.
+func (m *MongoSink) tickLoop() {
+	for {
+		select {
+		case <-m.ticker.C:
+			m.Flush()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Write buffers doc and flushes its (DB, Collection) bucket once it reaches
+// batchSize.
+func (m *MongoSink) Write(doc Document) error {
+	m.mu.Lock()
+	key := collKey{DB: doc.DB, Coll: doc.Coll}
+	m.buffers[key] = append(m.buffers[key], doc)
+	var flushDocs []Document
+	if len(m.buffers[key]) >= m.batchSize {
+		flushDocs = m.buffers[key]
+		delete(m.buffers, key)
+	}
+	m.mu.Unlock()
+
+	if flushDocs != nil {
+		m.flushBuffer(key, flushDocs)
+	}
+	return nil
+}
+
+// Flush bulk-inserts every buffered collection's pending documents.
+func (m *MongoSink) Flush() error {
+	m.mu.Lock()
+	pending := m.buffers
+	m.buffers = make(map[collKey][]Document)
+	m.mu.Unlock()
+
+	for key, docs := range pending {
+		if len(docs) == 0 {
+			continue
+		}
+		m.flushBuffer(key, docs)
+	}
+	return nil
+}
+
+// Close stops the flush ticker and drains any remaining buffers.
+func (m *MongoSink) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.ticker.Stop()
+	})
+	return m.Flush()
+}
+
+// flushBuffer bulk-inserts the buffered documents for a single (DB,
+// Collection) pair, re-queuing only the documents a partial bulk failure
+// rejected via expFalloff. Every doc that lands -- via the bulk insert here
+// or a later expFalloff retry -- is reported through onCommit, since that's
+// the first point a doc is actually durable.
+func (m *MongoSink) flushBuffer(key collKey, docs []Document) {
+	ssn := m.base.Copy()
+	defer ssn.Close()
+
+	insertDocs := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		insertDocs[i] = doc.Doc
+	}
+
+	bulk := ssn.DB(key.DB).C(key.Coll).Bulk()
+	// Unordered so one bad document doesn't abort the rest of the batch --
+	// in the default ordered mode mgo stops after the first failure and
+	// every doc after it is never attempted, not present in Cases(), and
+	// would otherwise be mistaken below for a successful insert.
+	bulk.Unordered()
+	bulk.Insert(insertDocs...)
+	start := time.Now()
+	_, err := bulk.Run()
+	m.flushLatency.WithLabelValues(key.DB, key.Coll).Observe(time.Since(start).Seconds())
+	if err == nil {
+		m.insertsTotal.WithLabelValues(key.DB, key.Coll).Add(float64(len(docs)))
+		if m.onCommit != nil {
+			for _, doc := range docs {
+				m.onCommit(doc)
+			}
+		}
+		return
+	}
+
+	if strings.Contains(err.Error(), "ObjectIDs") {
+		spew.Dump(docs)
+	}
+	m.log.WithFields(log.Fields{
+		"error": err.Error(),
+	}).Error("Database bulk write failure")
+
+	succeeded, failed := partitionBulkFailure(docs, err)
+	if len(succeeded) > 0 {
+		m.insertsTotal.WithLabelValues(key.DB, key.Coll).Add(float64(len(succeeded)))
+		if m.onCommit != nil {
+			for _, doc := range succeeded {
+				m.onCommit(doc)
+			}
+		}
+	}
+
+	for _, towrite := range failed {
+		m.expFalloff(towrite)
+	}
+}
+
+// bulkCaseLister is satisfied by *mgo.BulkError. It's pulled out as an
+// interface so partitionBulkFailure can be unit tested without a live bulk
+// insert -- mgo.BulkError's cases are otherwise only ever populated by mgo
+// itself.
+type bulkCaseLister interface {
+	Cases() []mgo.BulkErrorCase
+}
+
+// partitionBulkFailure splits docs into the ones a bulk insert actually
+// committed and the ones it rejected, using the per-document cases err
+// exposes via Cases(). If err doesn't expose cases at all (e.g. a network
+// error aborted the whole bulk before Mongo replied), every doc is treated
+// as failed and goes through expFalloff -- better to retry a document that
+// actually landed than to silently drop one that didn't.
+//
+// This only partitions correctly against an *unordered* bulk op: in mgo's
+// default ordered mode, a failure aborts every operation queued after it,
+// and those never-attempted docs are indistinguishable from succeeded ones
+// in Cases() alone.
+func partitionBulkFailure(docs []Document, err error) (succeeded, failed []Document) {
+	lister, ok := err.(bulkCaseLister)
+	if !ok {
+		return nil, docs
+	}
+
+	cases := lister.Cases()
+	failedIdx := make(map[int]bool, len(cases))
+	for _, c := range cases {
+		failedIdx[c.Index] = true
+	}
+
+	succeeded = make([]Document, 0, len(docs)-len(failedIdx))
+	failed = make([]Document, 0, len(failedIdx))
+	for i, doc := range docs {
+		if failedIdx[i] {
+			failed = append(failed, doc)
+		} else {
+			succeeded = append(succeeded, doc)
+		}
+	}
+	return succeeded, failed
+}
+
+// expFalloff is entered after a document is rejected by a bulk insert
+func (m *MongoSink) expFalloff(doc Document) {
+	for i := 0; i < 5; i++ {
+		time.Sleep(time.Duration(i*i) * time.Second)
+		m.retriesTotal.Inc()
+		ssn := m.base.Copy()
+		err := ssn.DB(doc.DB).C(doc.Coll).Insert(doc.Doc)
+		if err == nil {
+			ssn.Close()
+			m.insertsTotal.WithLabelValues(doc.DB, doc.Coll).Inc()
+			m.log.Info("Write succeeded")
+			if m.onCommit != nil {
+				m.onCommit(doc)
+			}
+			return
+		}
+		ssn.Close()
+		m.log.WithFields(log.Fields{
+			"error":   err.Error(),
+			"falloff": i,
+		}).Error("Database write failure")
+	}
+}
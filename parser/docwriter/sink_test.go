@@ -0,0 +1,70 @@
+package docwriter
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/mgo.v2"
+)
+
+// fakeBulkError lets us exercise partitionBulkFailure without a live mgo
+// bulk insert -- a real *mgo.BulkError's cases are only ever populated by
+// mgo itself.
+type fakeBulkError struct {
+	cases []mgo.BulkErrorCase
+}
+
+func (f *fakeBulkError) Cases() []mgo.BulkErrorCase { return f.cases }
+func (f *fakeBulkError) Error() string              { return "fake bulk error" }
+
+func TestPartitionBulkFailureSplitsByCaseIndex(t *testing.T) {
+	docs := []Document{
+		{DB: "d", Coll: "c", Offset: 1},
+		{DB: "d", Coll: "c", Offset: 2},
+		{DB: "d", Coll: "c", Offset: 3},
+	}
+	err := &fakeBulkError{cases: []mgo.BulkErrorCase{
+		{Index: 1, Err: errors.New("duplicate key")},
+	}}
+
+	succeeded, failed := partitionBulkFailure(docs, err)
+
+	if len(succeeded) != 2 || len(failed) != 1 {
+		t.Fatalf("expected 2 succeeded/1 failed, got %d/%d", len(succeeded), len(failed))
+	}
+	if failed[0].Offset != 2 {
+		t.Errorf("expected doc at index 1 (Offset 2) to be the failed one, got Offset %d", failed[0].Offset)
+	}
+}
+
+func TestPartitionBulkFailureTreatsUnrecognizedErrorAsAllFailed(t *testing.T) {
+	docs := []Document{
+		{DB: "d", Coll: "c", Offset: 1},
+		{DB: "d", Coll: "c", Offset: 2},
+	}
+
+	succeeded, failed := partitionBulkFailure(docs, errors.New("connection reset"))
+
+	if succeeded != nil {
+		t.Errorf("expected no succeeded docs when err exposes no cases, got %d", len(succeeded))
+	}
+	if len(failed) != len(docs) {
+		t.Errorf("expected every doc to be retried when err exposes no cases, got %d", len(failed))
+	}
+}
+
+func TestPartitionBulkFailureNoCasesMeansAllSucceeded(t *testing.T) {
+	docs := []Document{
+		{DB: "d", Coll: "c", Offset: 1},
+		{DB: "d", Coll: "c", Offset: 2},
+	}
+
+	succeeded, failed := partitionBulkFailure(docs, &fakeBulkError{})
+
+	if len(succeeded) != len(docs) {
+		t.Errorf("expected every doc to be reported succeeded when Cases() is empty, got %d", len(succeeded))
+	}
+	if len(failed) != 0 {
+		t.Errorf("expected no failed docs when Cases() is empty, got %d", len(failed))
+	}
+}
@@ -0,0 +1,163 @@
+package docwriter
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Whitelist matches Documents against a set of rules loaded from
+// config.SystemConfig.Whitelist. Each rule string is one of:
+//
+//	cidr:<CIDR>     source or destination IP falls inside the network
+//	suffix:<suffix> host ends in suffix (e.g. "suffix:.example.com")
+//	regex:<pattern> host or URL matches the compiled regex
+//	<anything else> exact host match
+type Whitelist struct {
+	exact   map[string]struct{}
+	suffix  []string // sorted, longest-suffix-first isn't required: we just scan
+	cidrs   *cidrNode
+	regexes []*regexp.Regexp
+}
+
+// NewWhitelist compiles rules into a Whitelist. Invalid cidr/regex rules are
+// skipped and reported in the returned error, but every rule that does parse
+// is still applied.
+func NewWhitelist(rules []string) (*Whitelist, error) {
+	wl := &Whitelist{
+		exact: make(map[string]struct{}),
+		cidrs: newCidrNode(),
+	}
+
+	var badRules []string
+	for _, raw := range rules {
+		rule := strings.TrimSpace(raw)
+		if rule == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(rule, "cidr:"):
+			_, network, err := net.ParseCIDR(strings.TrimPrefix(rule, "cidr:"))
+			if err != nil {
+				badRules = append(badRules, rule)
+				continue
+			}
+			wl.cidrs.insert(network)
+		case strings.HasPrefix(rule, "suffix:"):
+			suf := strings.TrimPrefix(rule, "suffix:")
+			if suf == "" {
+				// strings.HasSuffix(host, "") is true for every host, so an
+				// empty suffix would silently whitelist the entire import.
+				badRules = append(badRules, rule)
+				continue
+			}
+			wl.suffix = append(wl.suffix, suf)
+		case strings.HasPrefix(rule, "regex:"):
+			re, err := regexp.Compile(strings.TrimPrefix(rule, "regex:"))
+			if err != nil {
+				badRules = append(badRules, rule)
+				continue
+			}
+			wl.regexes = append(wl.regexes, re)
+		default:
+			wl.exact[rule] = struct{}{}
+		}
+	}
+	sort.Strings(wl.suffix)
+
+	if len(badRules) > 0 {
+		return wl, fmt.Errorf("docwriter: could not parse whitelist rules: %s", strings.Join(badRules, ", "))
+	}
+	return wl, nil
+}
+
+// Match reports whether doc should be treated as whitelisted.
+func (w *Whitelist) Match(doc Document) bool {
+	if w == nil {
+		return false
+	}
+
+	if _, ok := w.exact[doc.Host]; ok {
+		return true
+	}
+
+	for _, suf := range w.suffix {
+		if strings.HasSuffix(doc.Host, suf) {
+			return true
+		}
+	}
+
+	if ip := net.ParseIP(doc.SrcIP); ip != nil && w.cidrs.contains(ip) {
+		return true
+	}
+	if ip := net.ParseIP(doc.DstIP); ip != nil && w.cidrs.contains(ip) {
+		return true
+	}
+
+	for _, re := range w.regexes {
+		if re.MatchString(doc.Host) || re.MatchString(doc.URL) {
+			return true
+		}
+	}
+	return false
+}
+
+// cidrNode is a binary trie over the 128 bits of a (possibly IPv4-mapped)
+// IP address. Walking it costs at most 128 bit comparisons per lookup,
+// independent of how many CIDRs are loaded.
+type cidrNode struct {
+	children [2]*cidrNode
+	terminal bool
+}
+
+func newCidrNode() *cidrNode {
+	return &cidrNode{}
+}
+
+// insert marks network's prefix as whitelisted.
+func (n *cidrNode) insert(network *net.IPNet) {
+	ip := network.IP.To16()
+	ones, bits := network.Mask.Size()
+	if bits == 32 {
+		ones += 96 // walk the IPv4-mapped ::ffff:0:0/96 prefix
+	}
+
+	cur := n
+	for i := 0; i < ones; i++ {
+		b := bitAt(ip, i)
+		if cur.children[b] == nil {
+			cur.children[b] = &cidrNode{}
+		}
+		cur = cur.children[b]
+	}
+	cur.terminal = true
+}
+
+// contains reports whether ip falls under any inserted network. Any
+// terminal node encountered on the way down is a match, since a shorter
+// whitelisted prefix covers every address beneath it.
+func (n *cidrNode) contains(ip net.IP) bool {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return false
+	}
+
+	cur := n
+	for i := 0; i < 128; i++ {
+		if cur.terminal {
+			return true
+		}
+		cur = cur.children[bitAt(ip16, i)]
+		if cur == nil {
+			return false
+		}
+	}
+	return cur.terminal
+}
+
+func bitAt(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
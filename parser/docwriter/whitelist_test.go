@@ -0,0 +1,133 @@
+package docwriter
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewWhitelistValidRules(t *testing.T) {
+	wl, err := NewWhitelist([]string{
+		"example.com",
+		"suffix:.internal.example.com",
+		"cidr:10.0.0.0/8",
+		`regex:^/admin/.*`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wl == nil {
+		t.Fatal("expected a non-nil Whitelist")
+	}
+}
+
+func TestNewWhitelistBadRulesStillApplyTheRest(t *testing.T) {
+	wl, err := NewWhitelist([]string{
+		"example.com",
+		"cidr:not-a-cidr",
+		"regex:(unterminated",
+	})
+	if err == nil {
+		t.Fatal("expected an error listing the bad rules")
+	}
+	if !wl.Match(Document{Host: "example.com"}) {
+		t.Error("exact rule that parsed fine should still be applied despite the other bad rules")
+	}
+}
+
+func TestNewWhitelistRejectsEmptySuffix(t *testing.T) {
+	wl, err := NewWhitelist([]string{"example.com", "suffix:"})
+	if err == nil {
+		t.Fatal("expected an error for an empty suffix rule")
+	}
+	if wl.Match(Document{Host: "anything-at-all.test"}) {
+		t.Error("an empty suffix must not silently whitelist every host")
+	}
+	if !wl.Match(Document{Host: "example.com"}) {
+		t.Error("exact rule that parsed fine should still be applied despite the bad suffix rule")
+	}
+}
+
+func TestWhitelistMatchExact(t *testing.T) {
+	wl, err := NewWhitelist([]string{"example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !wl.Match(Document{Host: "example.com"}) {
+		t.Error("expected exact host match")
+	}
+	if wl.Match(Document{Host: "notexample.com"}) {
+		t.Error("did not expect a match for an unrelated host")
+	}
+}
+
+func TestWhitelistMatchSuffix(t *testing.T) {
+	wl, err := NewWhitelist([]string{"suffix:.example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !wl.Match(Document{Host: "www.example.com"}) {
+		t.Error("expected suffix match")
+	}
+	if wl.Match(Document{Host: "example.com.evil.com"}) {
+		t.Error("did not expect a match when the suffix only appears mid-string")
+	}
+}
+
+func TestWhitelistMatchCIDR(t *testing.T) {
+	wl, err := NewWhitelist([]string{"cidr:10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !wl.Match(Document{SrcIP: "10.1.2.3"}) {
+		t.Error("expected SrcIP inside the CIDR to match")
+	}
+	if !wl.Match(Document{DstIP: "10.1.2.3"}) {
+		t.Error("expected DstIP inside the CIDR to match")
+	}
+	if wl.Match(Document{SrcIP: "192.168.1.1"}) {
+		t.Error("did not expect an address outside the CIDR to match")
+	}
+}
+
+func TestWhitelistMatchRegex(t *testing.T) {
+	wl, err := NewWhitelist([]string{`regex:^/admin/.*`})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !wl.Match(Document{URL: "/admin/login"}) {
+		t.Error("expected URL matching the regex to match")
+	}
+	if !wl.Match(Document{Host: "/admin/login"}) {
+		t.Error("expected a regex rule to also be checked against Host")
+	}
+	if wl.Match(Document{URL: "/user/login"}) {
+		t.Error("did not expect a non-matching URL to match")
+	}
+}
+
+func TestWhitelistMatchNilWhitelist(t *testing.T) {
+	var wl *Whitelist
+	if wl.Match(Document{Host: "example.com"}) {
+		t.Error("a nil Whitelist should never match")
+	}
+}
+
+func TestCidrNodeCoversWholePrefix(t *testing.T) {
+	n := newCidrNode()
+	_, network, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n.insert(network)
+
+	if !n.contains(net.ParseIP("10.255.255.255")) {
+		t.Error("expected an address at the top of the /8 to be covered")
+	}
+	if n.contains(net.ParseIP("11.0.0.0")) {
+		t.Error("did not expect an address outside the /8 to be covered")
+	}
+}